@@ -0,0 +1,166 @@
+package traefik_modsecurity
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// wafBackend is a single ModSecurity endpoint and its health counters
+type wafBackend struct {
+	url                 string
+	target              *url.URL  // Parsed url, requests are built from it
+	ejected             bool      // If the backend is excluded from inspection until a probe succeeds
+	probing             bool      // If a probe of the ejected backend is in flight
+	nextProbe           time.Time // When the ejected backend may be probed again
+	consecutiveFailures int       // Failures since the last successful request
+	requests            int64     // Requests sent since startup
+	failures            int64     // Failed requests since startup
+}
+
+// backendStatus is the health of a single backend as reported on the health check path
+type backendStatus struct {
+	Url                 string `json:"url"`
+	Healthy             bool   `json:"healthy"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	Requests            int64  `json:"requests"`
+	Failures            int64  `json:"failures"`
+	Reachable           bool   `json:"reachable"`
+	Error               string `json:"error,omitempty"`
+}
+
+// wafBackendPool spreads inspections over the configured backends and ejects the failing ones.
+// Ejection only depends on consecutive failures, the request and failure counts are informative.
+// The last healthy backend is never ejected: when every backend fails, the global unhealthy
+// backoff applies exactly as with a single backend.
+// Ejected backends are probed lazily, when a request comes in after the probe interval, so that
+// instances discarded on a Traefik configuration reload leave no timers behind.
+type wafBackendPool struct {
+	mu               sync.Mutex
+	backends         []*wafBackend
+	next             int // Round-robin position
+	failureThreshold int
+	probeInterval    time.Duration
+	probe            func(ctx context.Context, url string) error
+	logger           *log.Logger
+}
+
+func newWafBackendPool(urls []string, failureThreshold int, probeInterval time.Duration, logger *log.Logger) (*wafBackendPool, error) {
+	pool := &wafBackendPool{
+		failureThreshold: failureThreshold,
+		probeInterval:    probeInterval,
+		logger:           logger,
+	}
+	for _, rawUrl := range urls {
+		target, err := url.Parse(rawUrl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modsecurity url %q: %w", rawUrl, err)
+		}
+		pool.backends = append(pool.backends, &wafBackend{url: rawUrl, target: target})
+	}
+	return pool, nil
+}
+
+// candidates returns the healthy backends, rotated so that consecutive requests start on different backends
+func (p *wafBackendPool) candidates() []*wafBackend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	healthy := make([]*wafBackend, 0, len(p.backends))
+	for _, backend := range p.backends {
+		if !backend.ejected {
+			healthy = append(healthy, backend)
+			continue
+		}
+		if !backend.probing && !now.Before(backend.nextProbe) {
+			backend.probing = true
+			go p.probeEjected(backend)
+		}
+	}
+	if len(healthy) == 0 {
+		// Should not happen as the last healthy backend is never ejected, but never return nothing to try
+		healthy = append(healthy, p.backends...)
+	}
+
+	// Rotate over the healthy backends only, so that an ejected backend's share is spread evenly
+	start := p.next % len(healthy)
+	p.next = start + 1
+	rotated := make([]*wafBackend, 0, len(healthy))
+	for i := range healthy {
+		rotated = append(rotated, healthy[(start+i)%len(healthy)])
+	}
+	return rotated
+}
+
+func (p *wafBackendPool) reportSuccess(backend *wafBackend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	backend.requests++
+	backend.consecutiveFailures = 0
+}
+
+func (p *wafBackendPool) reportFailure(backend *wafBackend, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	backend.requests++
+	backend.failures++
+	backend.consecutiveFailures++
+
+	if backend.ejected || backend.consecutiveFailures < p.failureThreshold || p.healthyCount() <= 1 {
+		return
+	}
+
+	backend.ejected = true
+	backend.nextProbe = time.Now().Add(p.probeInterval)
+	p.logger.Printf("ejecting modsec backend %s after %d consecutive failures: %s", backend.url, backend.consecutiveFailures, err.Error())
+}
+
+// probeEjected reinstates the backend if it answers, otherwise leaves it ejected until the next probe is due
+func (p *wafBackendPool) probeEjected(backend *wafBackend) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.probeInterval)
+	err := p.probe(ctx, backend.url)
+	cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	backend.probing = false
+	if err != nil {
+		backend.nextProbe = time.Now().Add(p.probeInterval)
+		return
+	}
+	backend.ejected = false
+	backend.consecutiveFailures = 0
+	p.logger.Printf("reinstating modsec backend %s after successful probe", backend.url)
+}
+
+// healthyCount must be called with mu held
+func (p *wafBackendPool) healthyCount() int {
+	count := 0
+	for _, backend := range p.backends {
+		if !backend.ejected {
+			count++
+		}
+	}
+	return count
+}
+
+func (p *wafBackendPool) status() []backendStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	statuses := make([]backendStatus, 0, len(p.backends))
+	for _, backend := range p.backends {
+		statuses = append(statuses, backendStatus{
+			Url:                 backend.url,
+			Healthy:             !backend.ejected,
+			ConsecutiveFailures: backend.consecutiveFailures,
+			Requests:            backend.requests,
+			Failures:            backend.failures,
+		})
+	}
+	return statuses
+}
@@ -0,0 +1,231 @@
+package traefik_modsecurity
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModsecurity_EjectsFailingBackend(t *testing.T) {
+	var healthyWafCalls int32
+	healthyWaf := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&healthyWafCalls, 1)
+		w.WriteHeader(200)
+	}))
+	defer healthyWaf.Close()
+
+	failingWaf := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	failingWaf.Close()
+
+	backendCalls := 0
+	httpServiceHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendCalls++
+		w.WriteHeader(200)
+	})
+
+	config := &Config{
+		TimeoutMillis:            2000,
+		ModSecurityUrl:           failingWaf.URL,
+		ModSecurityUrls:          []string{healthyWaf.URL},
+		BackendFailureThreshold:  1,
+		BackendProbeIntervalSecs: 60,
+		StatsLogIntervalSecs:     3600,
+	}
+
+	middleware, err := New(context.Background(), httpServiceHandler, config, "modsecurity-middleware")
+	if err != nil {
+		t.Fatalf("Failed to create middleware: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		rw := httptest.NewRecorder()
+		middleware.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusOK, rw.Result().StatusCode, "Requests should be inspected by the healthy backend")
+	}
+
+	assert.Equal(t, 4, backendCalls)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&healthyWafCalls))
+
+	stats := middleware.(*Modsecurity).stats
+	assert.Equal(t, int64(0), stats.wafErrors, "A request recovered by another backend is not a WAF error")
+	assert.Equal(t, int64(4), stats.inspected)
+
+	statuses := middleware.(*Modsecurity).backends.status()
+	assert.False(t, statuses[0].Healthy, "Failing backend should be ejected")
+	assert.Equal(t, int64(1), statuses[0].Failures, "Ejected backend should not receive more requests")
+	assert.True(t, statuses[1].Healthy)
+	assert.Equal(t, int64(0), statuses[1].Failures)
+}
+
+func TestWafBackendPool_NeverEjectsLastHealthyBackend(t *testing.T) {
+	pool, err := newWafBackendPool([]string{"http://waf-a", "http://waf-b"}, 1, time.Minute, log.New(os.Stdout, "", log.LstdFlags))
+	assert.NoError(t, err)
+	pool.probe = func(ctx context.Context, url string) error { return errors.New("down") }
+
+	pool.reportFailure(pool.backends[0], errors.New("down"))
+	pool.reportFailure(pool.backends[1], errors.New("down"))
+
+	assert.True(t, pool.backends[0].ejected)
+	assert.False(t, pool.backends[1].ejected)
+	assert.Equal(t, []*wafBackend{pool.backends[1]}, pool.candidates())
+}
+
+func TestWafBackendPool_SpreadsLoadOverHealthyBackends(t *testing.T) {
+	pool, err := newWafBackendPool([]string{"http://waf-a", "http://waf-b", "http://waf-c"}, 1, time.Minute, log.New(os.Stdout, "", log.LstdFlags))
+	assert.NoError(t, err)
+	pool.probe = func(ctx context.Context, url string) error { return errors.New("down") }
+	pool.reportFailure(pool.backends[1], errors.New("down"))
+
+	first := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		candidates := pool.candidates()
+		assert.Len(t, candidates, 2)
+		first[candidates[0].url]++
+	}
+	assert.Equal(t, map[string]int{"http://waf-a": 3, "http://waf-c": 3}, first)
+}
+
+func TestWafBackendPool_ReinstatesAfterSuccessfulProbe(t *testing.T) {
+	var probeFails int32 = 1
+	var probes int32
+	pool, err := newWafBackendPool([]string{"http://waf-a", "http://waf-b"}, 2, 10*time.Millisecond, log.New(os.Stdout, "", log.LstdFlags))
+	assert.NoError(t, err)
+	pool.probe = func(ctx context.Context, url string) error {
+		atomic.AddInt32(&probes, 1)
+		if atomic.LoadInt32(&probeFails) == 1 {
+			return errors.New("down")
+		}
+		return nil
+	}
+
+	pool.reportFailure(pool.backends[0], errors.New("down"))
+	assert.Len(t, pool.candidates(), 2, "Backend should stay in rotation below the failure threshold")
+
+	pool.reportFailure(pool.backends[0], errors.New("down"))
+	assert.Len(t, pool.candidates(), 1, "Backend should be ejected at the failure threshold")
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&probes), "Nothing should be probed without incoming requests")
+	assert.Len(t, pool.candidates(), 1, "Backend should stay ejected while probes fail")
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&probes) == 1 }, time.Second, time.Millisecond)
+
+	atomic.StoreInt32(&probeFails, 0)
+	assert.Eventually(t, func() bool { return len(pool.candidates()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, pool.status()[0].ConsecutiveFailures)
+}
+
+func TestModsecurity_CountsWafErrorOncePerRequest(t *testing.T) {
+	wafA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	wafA.Close()
+	wafB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	wafB.Close()
+
+	config := &Config{
+		TimeoutMillis:        2000,
+		ModSecurityUrl:       wafA.URL,
+		ModSecurityUrls:      []string{wafB.URL},
+		StatsLogIntervalSecs: 3600,
+	}
+
+	middleware, err := New(context.Background(), http.NotFoundHandler(), config, "modsecurity-middleware")
+	if err != nil {
+		t.Fatalf("Failed to create middleware: %v", err)
+	}
+
+	rw := httptest.NewRecorder()
+	middleware.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	assert.Equal(t, http.StatusBadGateway, rw.Result().StatusCode)
+	assert.Equal(t, int64(1), middleware.(*Modsecurity).stats.wafErrors)
+}
+
+func TestModsecurity_RetriesShareTheRequestTimeout(t *testing.T) {
+	slowWaf := func() *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * time.Second):
+			}
+		}))
+	}
+	wafA, wafB := slowWaf(), slowWaf()
+	defer wafA.Close()
+	defer wafB.Close()
+
+	config := &Config{
+		TimeoutMillis:   300,
+		ModSecurityUrl:  wafA.URL,
+		ModSecurityUrls: []string{wafB.URL},
+	}
+
+	middleware, err := New(context.Background(), http.NotFoundHandler(), config, "modsecurity-middleware")
+	if err != nil {
+		t.Fatalf("Failed to create middleware: %v", err)
+	}
+
+	start := time.Now()
+	rw := httptest.NewRecorder()
+	middleware.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/test", nil))
+	elapsed := time.Since(start)
+
+	assert.Equal(t, http.StatusBadGateway, rw.Result().StatusCode)
+	assert.Less(t, elapsed, 550*time.Millisecond, "Retries should not get a timeout of their own")
+
+	var failures int64
+	for _, status := range middleware.(*Modsecurity).backends.status() {
+		failures += status.Failures
+	}
+	assert.Equal(t, int64(1), failures, "Only the backend that used up the budget should be blamed")
+}
+
+func TestModsecurity_SlowBackendDoesNotDisableInspection(t *testing.T) {
+	slowWaf := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer slowWaf.Close()
+	blockingWaf := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer blockingWaf.Close()
+
+	backendCalls := 0
+	httpServiceHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendCalls++
+		w.WriteHeader(200)
+	})
+
+	config := &Config{
+		TimeoutMillis:                 300,
+		ModSecurityUrl:                slowWaf.URL,
+		ModSecurityUrls:               []string{blockingWaf.URL},
+		UnhealthyWafBackOffPeriodSecs: 30,
+	}
+
+	middleware, err := New(context.Background(), httpServiceHandler, config, "modsecurity-middleware")
+	if err != nil {
+		t.Fatalf("Failed to create middleware: %v", err)
+	}
+
+	// The slow backend is tried first and uses up the whole budget
+	rw := httptest.NewRecorder()
+	middleware.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/test", nil))
+	assert.Equal(t, http.StatusBadGateway, rw.Result().StatusCode, "The request should fail rather than go uninspected")
+	assert.False(t, middleware.(*Modsecurity).unhealthyWaf, "The WAF should not back off while a backend was left untried")
+
+	rw = httptest.NewRecorder()
+	middleware.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/test", nil))
+	assert.Equal(t, http.StatusForbidden, rw.Result().StatusCode, "Later requests should still be inspected")
+	assert.Equal(t, 0, backendCalls)
+}
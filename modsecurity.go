@@ -4,12 +4,15 @@ package traefik_modsecurity
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -28,6 +31,11 @@ var bodyBufferPool = sync.Pool{
 type Config struct {
 	TimeoutMillis                  int64    `json:"timeoutMillis,omitempty"`
 	ModSecurityUrl                 string   `json:"modSecurityUrl,omitempty"`
+	ModSecurityUrls                []string `json:"modSecurityUrls,omitempty"`                // Additional WAF endpoints, requests are spread over all healthy ones
+	BackendFailureThreshold        int      `json:"backendFailureThreshold,omitempty"`        // Consecutive failures before a backend is ejected (default 3)
+	BackendProbeIntervalSecs       int      `json:"backendProbeIntervalSecs,omitempty"`       // Interval between probes of an ejected backend (default 5s)
+	RejectMalformedUrlEncoding     bool     `json:"rejectMalformedUrlEncoding,omitempty"`     // If true, reject request URIs with invalid percent-encoding with 400
+	NormalizeUrlPath               bool     `json:"normalizeUrlPath,omitempty"`               // If true, normalize the request path (RFC 3986) for both the WAF and the upstream
 	UnhealthyWafBackOffPeriodSecs  int      `json:"unhealthyWafBackOffPeriodSecs,omitempty"`  // If the WAF is unhealthy, back off
 	ModSecurityStatusRequestHeader string   `json:"modSecurityStatusRequestHeader,omitempty"` // Header name to add to request when blocked (for logging)
 	MaxConnsPerHost                int      `json:"maxConnsPerHost,omitempty"`                // Maximum connections per host (0 = unlimited, original default)
@@ -36,14 +44,36 @@ type Config struct {
 	ExpectContinueTimeoutMillis    int64    `json:"expectContinueTimeoutMillis,omitempty"`    // Timeout for Expect: 100-continue (default 1000ms)
 	MaxBodySizeBytes               int64    `json:"maxBodySizeBytes,omitempty"`               // Maximum request body size in bytes (0 = unlimited, default 5MB)
 	MaxBodySizeBytesForPool        int64    `json:"maxBodySizeBytesForPool,omitempty"`        // Threshold above which to use ad-hoc allocation instead of pool (default 4MB)
+	MaxBodySizeExceededAction      string   `json:"maxBodySizeExceededAction,omitempty"`      // What to do with bodies over MaxBodySizeBytes: "reject" with 413 (default) or "skip" inspection
 	IgnoreBodyForVerbs             []string `json:"ignoreBodyForVerbs,omitempty"`             // HTTP verbs for which body should not be read (default: HEAD, GET, DELETE)
 	IgnoreBodyForVerbsDeny         bool     `json:"ignoreBodyForVerbsDeny,omitempty"`         // If true, reject requests with body for verbs in IgnoreBodyForVerbs
+	TlsMinVersion                  string   `json:"tlsMinVersion,omitempty"`                  // Minimum TLS version for the WAF connection (default "1.2")
+	TlsMaxVersion                  string   `json:"tlsMaxVersion,omitempty"`                  // Maximum TLS version for the WAF connection (empty = highest supported)
+	TlsCipherSuites                []string `json:"tlsCipherSuites,omitempty"`                // Allowed TLS 1.0-1.2 cipher suite names (empty = Go defaults)
+	TlsNextProtos                  []string `json:"tlsNextProtos,omitempty"`                  // ALPN protocols to offer (empty = h2 and http/1.1)
+	TlsServerName                  string   `json:"tlsServerName,omitempty"`                  // SNI and certificate name to use instead of the modSecurityUrl host
+	HealthCheckPath                string   `json:"healthCheckPath,omitempty"`                // Reserved path answered by the middleware with the WAF health status (empty = disabled)
+	HealthCheckTimeoutMillis       int64    `json:"healthCheckTimeoutMillis,omitempty"`       // Timeout for the WAF probes made by the health check (default 500ms)
+	HealthCheckDetails             bool     `json:"healthCheckDetails,omitempty"`             // If true, the health check also reports every backend URL, counters and probe error
+	StatsLogIntervalSecs           int      `json:"statsLogIntervalSecs,omitempty"`           // Interval between summary statistics log lines (0 = disabled)
+	DialTimeoutMillis              int64    `json:"dialTimeoutMillis,omitempty"`              // Timeout for establishing connections, including DNS resolution (default 30000ms)
+	DialKeepAliveMillis            int64    `json:"dialKeepAliveMillis,omitempty"`            // TCP keep-alive period (default 30000ms, negative = disabled)
+	IdleConnTimeoutMillis          int64    `json:"idleConnTimeoutMillis,omitempty"`          // How long idle connections are kept in the pool (default 90000ms)
+	MaxIdleConns                   int      `json:"maxIdleConns,omitempty"`                   // Maximum idle connections across all hosts (default 100)
+	QuarantineSize                 int      `json:"quarantineSize,omitempty"`                 // Number of blocked requests kept in memory for troubleshooting (0 = disabled)
+	QuarantineMaxBodyBytes         int      `json:"quarantineMaxBodyBytes,omitempty"`         // Body bytes kept per quarantined request (default 4KB)
+	QuarantineRedactHeaders        []string `json:"quarantineRedactHeaders,omitempty"`        // Headers whose values are not stored (default: Authorization, Proxy-Authorization, Cookie)
+	QuarantineRedactPatterns       []string `json:"quarantineRedactPatterns,omitempty"`       // Regular expressions redacted from the stored request URI, header values and body
+	QuarantinePath                 string   `json:"quarantinePath,omitempty"`                 // Reserved path answered by the middleware with the quarantined requests (empty = disabled)
+	QuarantineToken                string   `json:"quarantineToken,omitempty"`                // Shared secret required as "Authorization: Bearer <token>" on quarantinePath (mandatory with quarantinePath)
 }
 
 // CreateConfig creates the default plugin configuration.
 func CreateConfig() *Config {
 	return &Config{
 		TimeoutMillis:                  2000,                                                             // Original default: 2 seconds
+		BackendFailureThreshold:        3,                                                                // Eject a backend after 3 consecutive failures
+		BackendProbeIntervalSecs:       5,                                                                // Probe ejected backends every 5 seconds
 		UnhealthyWafBackOffPeriodSecs:  0,                                                                // 0 to NOT backoff (original behaviour)
 		ModSecurityStatusRequestHeader: "",                                                               // Empty string means no header will be added
 		MaxConnsPerHost:                100,                                                              // Limit concurrent connections per host (was 0 = unlimited)
@@ -52,35 +82,76 @@ func CreateConfig() *Config {
 		ExpectContinueTimeoutMillis:    1000,                                                             // 1 second (original default)
 		MaxBodySizeBytes:               8 * 1024 * 1024,                                                  // 8 MB default
 		MaxBodySizeBytesForPool:        5 * 1024 * 1024,                                                  // 5 MB default for pool threshold
+		MaxBodySizeExceededAction:      "reject",                                                         // Default: reject oversized bodies with 413
 		IgnoreBodyForVerbs:             []string{"HEAD", "GET", "DELETE", "OPTIONS", "TRACE", "CONNECT"}, // Default verbs to ignore body
 		IgnoreBodyForVerbsDeny:         false,                                                            // Default: permissive body validation
+		TlsMinVersion:                  "1.2",                                                            // Original default: TLS 1.2
+		DialTimeoutMillis:              30000,                                                            // Original default: 30 seconds
+		DialKeepAliveMillis:            30000,                                                            // Original default: 30 seconds
+		IdleConnTimeoutMillis:          90000,                                                            // Original default: 90 seconds
+		MaxIdleConns:                   100,                                                              // Original default: 100
+		QuarantineSize:                 0,                                                                // 0 to NOT keep blocked requests
+		QuarantineMaxBodyBytes:         4096,                                                             // 4 KB of body per quarantined request
+		QuarantineRedactHeaders:        []string{"Authorization", "Proxy-Authorization", "Cookie"},       // Credentials are never stored
 	}
 }
 
 // Modsecurity a Modsecurity plugin.
 type Modsecurity struct {
 	next                           http.Handler
-	modSecurityUrl                 string
+	backends                       *wafBackendPool
 	name                           string
 	httpClient                     *http.Client
 	logger                         *log.Logger
 	unhealthyWafBackOffPeriodSecs  int
-	unhealthyWaf                   bool // If the WAF is unhealthy
+	unhealthyWaf                   bool      // If the WAF is unhealthy
+	unhealthyWafUntil              time.Time // When the current unhealthy backoff expires
 	unhealthyWafMutex              sync.Mutex
-	modSecurityStatusRequestHeader string          // Header name to add to request when blocked (for logging)
-	maxBodySizeBytes               int64           // Maximum request body size in bytes
-	maxBodySizeBytesForPool        int64           // Threshold above which to use ad-hoc allocation instead of pool
-	ignoreBodyForVerbs             map[string]bool // HTTP verbs for which body should not be read
-	ignoreBodyForVerbsDeny         bool            // If true, reject requests with body for verbs in ignoreBodyForVerbs
+	modSecurityStatusRequestHeader string           // Header name to add to request when blocked (for logging)
+	maxBodySizeBytes               int64            // Maximum request body size in bytes
+	maxBodySizeBytesForPool        int64            // Threshold above which to use ad-hoc allocation instead of pool
+	skipInspectionWhenBodyTooLarge bool             // If true, forward bodies over maxBodySizeBytes uninspected instead of rejecting them
+	rejectMalformedUrlEncoding     bool             // If true, reject request URIs with invalid percent-encoding
+	normalizeUrlPath               bool             // If true, normalize the request path before inspection
+	ignoreBodyForVerbs             map[string]bool  // HTTP verbs for which body should not be read
+	ignoreBodyForVerbsDeny         bool             // If true, reject requests with body for verbs in ignoreBodyForVerbs
+	healthCheckPath                string           // Reserved path answered with the WAF health status
+	healthCheckTimeout             time.Duration    // Timeout for the WAF probes made by the health check
+	healthCheckDetails             bool             // If true, the health check reports per backend details
+	healthProbeMutex               sync.Mutex       // Serializes health check probes so concurrent callers share the results
+	healthProbeResults             []error          // Last probe result per backend
+	healthProbeTime                time.Time        // When healthProbeResults were obtained
+	stats                          *requestStats    // Periodic summary statistics (nil = disabled)
+	quarantine                     *quarantineStore // Last blocked requests (nil = disabled)
+	quarantinePath                 string           // Reserved path answered with the quarantined requests
+	quarantineToken                string           // Shared secret required to read the quarantined requests
 }
 
 // New creates a new Modsecurity plugin with the given configuration.
 // It returns an HTTP handler that can be integrated into the Traefik middleware chain.
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	if len(config.ModSecurityUrl) == 0 {
+	var urls []string
+	if len(config.ModSecurityUrl) > 0 {
+		urls = append(urls, config.ModSecurityUrl)
+	}
+	for _, modSecurityUrl := range config.ModSecurityUrls {
+		if len(modSecurityUrl) > 0 && !containsString(urls, modSecurityUrl) {
+			urls = append(urls, modSecurityUrl)
+		}
+	}
+	if len(urls) == 0 {
 		return nil, fmt.Errorf("modSecurityUrl cannot be empty")
 	}
 
+	var skipInspectionWhenBodyTooLarge bool
+	switch strings.ToLower(config.MaxBodySizeExceededAction) {
+	case "", "reject":
+	case "skip":
+		skipInspectionWhenBodyTooLarge = true
+	default:
+		return nil, fmt.Errorf("invalid maxBodySizeExceededAction %q, expected reject or skip", config.MaxBodySizeExceededAction)
+	}
+
 	// Use a custom client with configurable timeout
 	var timeout time.Duration
 	if config.TimeoutMillis == 0 {
@@ -89,27 +160,40 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		timeout = time.Duration(config.TimeoutMillis) * time.Millisecond
 	}
 
-	// dialer is a custom net.Dialer with a specified timeout and keep-alive duration.
-	dialer := &net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
+	tlsConfig, err := createTlsConfig(config)
+	if err != nil {
+		return nil, err
 	}
 
+	// dialer is a custom net.Dialer with a configurable timeout and keep-alive duration.
+	dialer := createDialer(config)
+
 	// transport is a custom http.Transport with configurable timeouts and connection limits
 	transport := &http.Transport{
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig: &tls.Config{
-			MinVersion: tls.VersionTLS12,
-		},
-		ForceAttemptHTTP2: true,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 			return dialer.DialContext(ctx, network, addr)
 		},
 	}
 
+	// Forcing HTTP/2 would add "h2" back to the ALPN list, so only do it when it was offered
+	if len(tlsConfig.NextProtos) > 0 && !containsString(tlsConfig.NextProtos, "h2") {
+		transport.ForceAttemptHTTP2 = false
+	}
+
+	// Configure idle connection pool (0 = original defaults)
+	if config.MaxIdleConns > 0 {
+		transport.MaxIdleConns = config.MaxIdleConns
+	}
+	if config.IdleConnTimeoutMillis > 0 {
+		transport.IdleConnTimeout = time.Duration(config.IdleConnTimeoutMillis) * time.Millisecond
+	}
+
 	// Configure connection limits (0 = unlimited, original behavior)
 	if config.MaxConnsPerHost > 0 {
 		transport.MaxConnsPerHost = config.MaxConnsPerHost
@@ -128,19 +212,183 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		transport.ExpectContinueTimeout = time.Duration(config.ExpectContinueTimeoutMillis) * time.Millisecond
 	}
 
-	return &Modsecurity{
-		modSecurityUrl:                 config.ModSecurityUrl,
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+
+	var stats *requestStats
+	if config.StatsLogIntervalSecs > 0 {
+		stats = newRequestStats(logger, name, time.Duration(config.StatsLogIntervalSecs)*time.Second)
+	}
+
+	// The quarantine path is answered on the protected router itself, so it is only ever served to
+	// callers presenting the shared secret
+	if config.QuarantinePath != "" && config.QuarantineToken == "" {
+		return nil, fmt.Errorf("quarantineToken is required when quarantinePath is set")
+	}
+
+	var quarantine *quarantineStore
+	if config.QuarantineSize > 0 {
+		redactPatterns, err := compileRedactPatterns(config.QuarantineRedactPatterns)
+		if err != nil {
+			return nil, err
+		}
+		maxBodyBytes := config.QuarantineMaxBodyBytes
+		if maxBodyBytes <= 0 {
+			maxBodyBytes = 4096
+		}
+		redactHeaders := config.QuarantineRedactHeaders
+		if redactHeaders == nil {
+			redactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+		}
+		quarantine = newQuarantineStore(config.QuarantineSize, maxBodyBytes, redactHeaders, redactPatterns)
+	}
+
+	healthCheckTimeout := 500 * time.Millisecond
+	if config.HealthCheckTimeoutMillis > 0 {
+		healthCheckTimeout = time.Duration(config.HealthCheckTimeoutMillis) * time.Millisecond
+	}
+
+	failureThreshold := config.BackendFailureThreshold
+	if failureThreshold <= 0 {
+		failureThreshold = 3
+	}
+	probeInterval := 5 * time.Second
+	if config.BackendProbeIntervalSecs > 0 {
+		probeInterval = time.Duration(config.BackendProbeIntervalSecs) * time.Second
+	}
+
+	a := &Modsecurity{
 		next:                           next,
 		name:                           name,
 		httpClient:                     &http.Client{Timeout: timeout, Transport: transport},
-		logger:                         log.New(os.Stdout, "", log.LstdFlags),
+		logger:                         logger,
 		unhealthyWafBackOffPeriodSecs:  config.UnhealthyWafBackOffPeriodSecs,
 		modSecurityStatusRequestHeader: config.ModSecurityStatusRequestHeader,
 		maxBodySizeBytes:               config.MaxBodySizeBytes,
 		maxBodySizeBytesForPool:        config.MaxBodySizeBytesForPool,
+		skipInspectionWhenBodyTooLarge: skipInspectionWhenBodyTooLarge,
+		rejectMalformedUrlEncoding:     config.RejectMalformedUrlEncoding,
+		normalizeUrlPath:               config.NormalizeUrlPath,
 		ignoreBodyForVerbs:             createIgnoreBodyMap(config.IgnoreBodyForVerbs),
 		ignoreBodyForVerbsDeny:         config.IgnoreBodyForVerbsDeny,
-	}, nil
+		healthCheckPath:                config.HealthCheckPath,
+		healthCheckTimeout:             healthCheckTimeout,
+		healthCheckDetails:             config.HealthCheckDetails,
+		stats:                          stats,
+		quarantine:                     quarantine,
+		quarantinePath:                 config.QuarantinePath,
+		quarantineToken:                config.QuarantineToken,
+	}
+	a.backends, err = newWafBackendPool(urls, failureThreshold, probeInterval, logger)
+	if err != nil {
+		return nil, err
+	}
+	a.backends.probe = a.probeWaf
+	return a, nil
+}
+
+// createDialer builds the net.Dialer used to reach the WAF
+func createDialer(config *Config) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if config.DialTimeoutMillis > 0 {
+		dialer.Timeout = time.Duration(config.DialTimeoutMillis) * time.Millisecond
+	}
+	if config.DialKeepAliveMillis != 0 {
+		// Negative values disable keep-alive probes, as in net.Dialer
+		dialer.KeepAlive = time.Duration(config.DialKeepAliveMillis) * time.Millisecond
+	}
+	return dialer
+}
+
+// tlsVersions maps the accepted configuration values to TLS protocol versions
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// createTlsConfig builds the TLS client configuration used to reach the WAF
+func createTlsConfig(config *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if config.TlsMinVersion != "" {
+		version, ok := tlsVersions[config.TlsMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid tlsMinVersion %q, expected one of 1.0, 1.1, 1.2, 1.3", config.TlsMinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if config.TlsMaxVersion != "" {
+		version, ok := tlsVersions[config.TlsMaxVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid tlsMaxVersion %q, expected one of 1.0, 1.1, 1.2, 1.3", config.TlsMaxVersion)
+		}
+		if version < tlsConfig.MinVersion {
+			return nil, fmt.Errorf("tlsMaxVersion %q cannot be lower than the minimum TLS version", config.TlsMaxVersion)
+		}
+		tlsConfig.MaxVersion = version
+	}
+
+	if len(config.TlsCipherSuites) > 0 {
+		// TLS 1.3 suites are not configurable in Go, a list that cannot apply is an error rather than silently ignored
+		if tlsConfig.MinVersion >= tls.VersionTLS13 {
+			return nil, fmt.Errorf("tlsCipherSuites cannot be restricted when the minimum TLS version is 1.3")
+		}
+		available := make(map[string]*tls.CipherSuite)
+		for _, suite := range tls.CipherSuites() {
+			available[suite.Name] = suite
+		}
+		insecure := make(map[string]bool)
+		for _, suite := range tls.InsecureCipherSuites() {
+			insecure[suite.Name] = true
+		}
+		for _, name := range config.TlsCipherSuites {
+			if insecure[name] {
+				return nil, fmt.Errorf("insecure TLS cipher suite %q is not allowed", name)
+			}
+			suite, ok := available[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown TLS cipher suite %q", name)
+			}
+			if !supportsTlsBelow13(suite) {
+				return nil, fmt.Errorf("TLS cipher suite %q is a TLS 1.3 suite, which cannot be configured", name)
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, suite.ID)
+		}
+	}
+
+	if len(config.TlsNextProtos) > 0 {
+		tlsConfig.NextProtos = append([]string(nil), config.TlsNextProtos...)
+	}
+
+	// Used both for SNI and for verifying the certificate, e.g. when dialing a pod IP with a service certificate
+	tlsConfig.ServerName = config.TlsServerName
+
+	return tlsConfig, nil
+}
+
+func supportsTlsBelow13(suite *tls.CipherSuite) bool {
+	for _, version := range suite.SupportedVersions {
+		if version < tls.VersionTLS13 {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 // createIgnoreBodyMap converts a slice of verbs to a map for O(1) lookup
@@ -153,7 +401,28 @@ func createIgnoreBodyMap(verbs []string) map[string]bool {
 }
 
 func (a *Modsecurity) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if a.healthCheckPath != "" && req.URL.Path == a.healthCheckPath {
+		a.serveHealthCheck(rw)
+		return
+	}
+
+	if a.quarantinePath != "" && req.URL.Path == a.quarantinePath {
+		a.serveQuarantine(rw, req)
+		return
+	}
+
+	if !a.applyUrlPolicy(req) {
+		a.logger.Printf("malformed percent-encoding in request URI, rejecting")
+		if a.modSecurityStatusRequestHeader != "" {
+			req.Header.Set(a.modSecurityStatusRequestHeader, "blocked")
+		}
+		http.Error(rw, "Malformed URL encoding", http.StatusBadRequest)
+		a.stats.recordRejected(http.StatusBadRequest)
+		return
+	}
+
 	if isWebsocket(req) {
+		a.stats.recordBypassed("websocket")
 		a.next.ServeHTTP(rw, req)
 		return
 	}
@@ -163,6 +432,7 @@ func (a *Modsecurity) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		if a.modSecurityStatusRequestHeader != "" {
 			req.Header.Set(a.modSecurityStatusRequestHeader, "unhealthy")
 		}
+		a.stats.recordBypassed("unhealthy")
 		a.next.ServeHTTP(rw, req)
 		return
 	}
@@ -176,6 +446,7 @@ func (a *Modsecurity) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			// Request has a body, but this method should not have one
 			a.logger.Printf("HTTP %s request should not have a body, rejecting", req.Method)
 			http.Error(rw, fmt.Sprintf("HTTP %s requests should not have a body", req.Method), http.StatusBadRequest)
+			a.stats.recordRejected(http.StatusBadRequest)
 			return
 		}
 		// No body detected, continue processing
@@ -184,9 +455,18 @@ func (a *Modsecurity) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	// Check if we should skip body reading for this HTTP method
 	var body []byte
 	if !a.ignoreBodyForVerbs[req.Method] {
-		// Limit body size if configured (security optimization)
+		// Check the declared length before buffering anything so oversized bodies never reach memory
+		if a.maxBodySizeBytes > 0 && req.ContentLength > a.maxBodySizeBytes {
+			a.logger.Printf("request body too large: declared %d bytes (limit: %d bytes)", req.ContentLength, a.maxBodySizeBytes)
+			a.handleBodyTooLarge(rw, req, nil)
+			return
+		}
+
+		// Bodies without a declared length (chunked) are read up to one byte past the limit,
+		// which is enough to tell that they exceed it
+		var bodyReader io.Reader = req.Body
 		if a.maxBodySizeBytes > 0 {
-			req.Body = http.MaxBytesReader(rw, req.Body, a.maxBodySizeBytes)
+			bodyReader = io.LimitReader(req.Body, a.maxBodySizeBytes+1)
 		}
 
 		// Check Content-Length to decide whether to use pool or ad-hoc allocation
@@ -205,17 +485,7 @@ func (a *Modsecurity) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			defer bodyBufferPool.Put(buf)
 
 			// Read body into pooled buffer
-			if _, err := io.Copy(buf, req.Body); err != nil {
-				// Check if this is a MaxBytesError (body too large)
-				if maxBytesErr, ok := err.(*http.MaxBytesError); ok {
-					a.logger.Printf("request body too large: %d bytes (limit: %d bytes)", maxBytesErr.Limit, a.maxBodySizeBytes)
-					// Mark the request as blocked by the middleware itself (for access-log correlation)
-					if a.modSecurityStatusRequestHeader != "" {
-						req.Header.Set(a.modSecurityStatusRequestHeader, "blocked")
-					}
-					http.Error(rw, "Request body too large", http.StatusRequestEntityTooLarge) // 413
-					return
-				}
+			if _, err := io.Copy(buf, bodyReader); err != nil {
 				a.logger.Printf("fail to read incoming request: %s", err.Error())
 				http.Error(rw, "", http.StatusBadGateway)
 				return
@@ -227,18 +497,8 @@ func (a *Modsecurity) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			// - send it to ModSecurity, and
 			// - restore it for the downstream handler (Traefik backend),
 			// otherwise Traefik will see a Content-Length with an empty body and return 500.
-			largeBody, err := io.ReadAll(req.Body)
+			largeBody, err := io.ReadAll(bodyReader)
 			if err != nil {
-				// Check if this is a MaxBytesError (body too large)
-				if maxBytesErr, ok := err.(*http.MaxBytesError); ok {
-					a.logger.Printf("request body too large: %d bytes (limit: %d bytes)", maxBytesErr.Limit, a.maxBodySizeBytes)
-					// Mark the request as blocked by the middleware itself (for access-log correlation)
-					if a.modSecurityStatusRequestHeader != "" {
-						req.Header.Set(a.modSecurityStatusRequestHeader, "blocked")
-					}
-					http.Error(rw, "Request body too large", http.StatusRequestEntityTooLarge) // 413
-					return
-				}
 				a.logger.Printf("fail to read incoming request: %s", err.Error())
 				http.Error(rw, "", http.StatusBadGateway)
 				return
@@ -247,40 +507,84 @@ func (a *Modsecurity) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			// to avoid polluting the buffer pool with very large allocations.
 			body = largeBody
 		}
+
+		if a.maxBodySizeBytes > 0 && int64(len(body)) > a.maxBodySizeBytes {
+			a.logger.Printf("request body too large: more than %d bytes without a matching Content-Length", a.maxBodySizeBytes)
+			a.handleBodyTooLarge(rw, req, body)
+			return
+		}
 		// Don't restore req.Body yet - only create reader when needed
 	}
 
-	url := a.modSecurityUrl + req.RequestURI
-
-	// Create request body reader (nil for methods that ignore body)
-	var bodyReader io.Reader
-	if body != nil {
-		bodyReader = bytes.NewReader(body)
+	// Try the healthy backends in turn until one of them gives a verdict. All the attempts share
+	// a single timeoutMillis budget, retries never extend the time a request can wait for the WAF.
+	ctx := context.Background()
+	if a.httpClient.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.httpClient.Timeout)
+		defer cancel()
 	}
+	var resp *http.Response
+	var latency time.Duration
+	var err error
+	var backendUrl string // Last backend tried, for logging
+	candidates := a.backends.candidates()
+	tried := 0
+	for _, backend := range candidates {
+		if ctx.Err() != nil {
+			// Out of time, the remaining backends were not tried so they are not blamed
+			if err == nil {
+				err = ctx.Err()
+			}
+			break
+		}
+		tried++
+		backendUrl = backend.url
+		proxyReq, reqErr := newProxyRequest(ctx, backend.target, req, body)
+		if reqErr != nil {
+			if a.modSecurityStatusRequestHeader != "" {
+				req.Header.Set(a.modSecurityStatusRequestHeader, "cannotforward")
+			}
+			a.logger.Printf("fail to prepare forwarded request for modsec %s: %s", backend.url, reqErr.Error())
+			http.Error(rw, "", http.StatusBadGateway)
+			a.stats.recordWafError()
+			return
+		}
 
-	proxyReq, err := http.NewRequest(req.Method, url, bodyReader)
-	if err != nil {
-		if a.modSecurityStatusRequestHeader != "" {
-			req.Header.Set(a.modSecurityStatusRequestHeader, "cannotforward")
+		start := time.Now()
+		resp, err = a.httpClient.Do(proxyReq)
+		latency = time.Since(start)
+		if err == nil {
+			a.backends.reportSuccess(backend)
+			break
 		}
-		a.logger.Printf("fail to prepare forwarded request: %s", err.Error())
-		http.Error(rw, "", http.StatusBadGateway)
-		return
+		if urlErr, ok := err.(*url.Error); ok {
+			// The error names the raw request target without the host, the backend url is logged instead
+			err = urlErr.Err
+		}
+		a.backends.reportFailure(backend, err)
 	}
+	if err != nil {
+		// Counted once per request, however many backends were tried
+		a.stats.recordWafError()
 
-	// We may want to filter some headers, otherwise we could just use a shallow copy
-	proxyReq.Header = make(http.Header, len(req.Header))
-	for h, val := range req.Header {
-		proxyReq.Header[h] = val
-	}
+		// A slow backend used up the budget before the others could be tried: only this request
+		// fails, the WAF as a whole is not deemed unhealthy while healthy backends remain untried
+		if tried < len(candidates) {
+			if a.modSecurityStatusRequestHeader != "" {
+				req.Header.Set(a.modSecurityStatusRequestHeader, "error")
+			}
+			a.logger.Printf("fail to send HTTP request to modsec %s: timeout reached after %d of %d backends: %s", backendUrl, tried, len(candidates), err.Error())
+			http.Error(rw, "", http.StatusBadGateway)
+			return
+		}
 
-	resp, err := a.httpClient.Do(proxyReq)
-	if err != nil {
 		if a.unhealthyWafBackOffPeriodSecs > 0 {
 			a.unhealthyWafMutex.Lock()
 			if !a.unhealthyWaf {
-				a.logger.Printf("marking modsec as unhealthy for %ds fail to send HTTP request to modsec: %s", a.unhealthyWafBackOffPeriodSecs, err.Error())
+				a.logger.Printf("marking modsec as unhealthy for %ds fail to send HTTP request to modsec %s: %s", a.unhealthyWafBackOffPeriodSecs, backendUrl, err.Error())
 				a.unhealthyWaf = true
+				a.unhealthyWafUntil = time.Now().Add(time.Duration(a.unhealthyWafBackOffPeriodSecs) * time.Second)
 				if a.modSecurityStatusRequestHeader != "" {
 					req.Header.Set(a.modSecurityStatusRequestHeader, "error")
 				}
@@ -296,11 +600,12 @@ func (a *Modsecurity) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			if body != nil {
 				req.Body = io.NopCloser(bytes.NewReader(body))
 			}
+			a.stats.recordBypassed("unhealthy")
 			a.next.ServeHTTP(rw, req)
 			return
 		}
 
-		a.logger.Printf("fail to send HTTP request to modsec: %s", err.Error())
+		a.logger.Printf("fail to send HTTP request to modsec %s: %s", backendUrl, err.Error())
 		http.Error(rw, "", http.StatusBadGateway)
 		return
 	}
@@ -311,10 +616,14 @@ func (a *Modsecurity) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		if a.modSecurityStatusRequestHeader != "" {
 			req.Header.Set(a.modSecurityStatusRequestHeader, "blocked")
 		}
+		a.stats.recordBlocked(resp.StatusCode, latency)
+		a.quarantine.record(req, body, resp.StatusCode)
 		forwardResponse(resp, rw)
 		return
 	}
 
+	a.stats.recordAllowed(latency)
+
 	// Only restore req.Body when actually passing through and body was read
 	if body != nil {
 		req.Body = io.NopCloser(bytes.NewReader(body))
@@ -322,6 +631,245 @@ func (a *Modsecurity) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	a.next.ServeHTTP(rw, req)
 }
 
+// healthStatus is the payload returned on the health check path
+type healthStatus struct {
+	Status               string          `json:"status"`
+	WafReachable         bool            `json:"wafReachable"`
+	BackoffRemainingSecs int64           `json:"backoffRemainingSecs"`
+	Error                string          `json:"error,omitempty"`
+	Backends             []backendStatus `json:"backends,omitempty"`
+}
+
+// serveHealthCheck probes the WAF and reports whether requests can currently be inspected.
+// It answers 200 when the WAF is reachable and not backing off, 503 otherwise.
+// The path is public on the protected router: backend URLs and errors are only reported when
+// healthCheckDetails is enabled.
+func (a *Modsecurity) serveHealthCheck(rw http.ResponseWriter) {
+	// Health checks are polled regularly, they emit the last interval when traffic stops
+	a.stats.flush()
+
+	status := healthStatus{Status: "healthy"}
+
+	a.unhealthyWafMutex.Lock()
+	if a.unhealthyWaf {
+		// Round up so that a pending backoff never reports 0 seconds
+		remaining := time.Until(a.unhealthyWafUntil)
+		status.BackoffRemainingSecs = int64((remaining + time.Second - 1) / time.Second)
+		if status.BackoffRemainingSecs < 0 {
+			status.BackoffRemainingSecs = 0
+		}
+	}
+	a.unhealthyWafMutex.Unlock()
+
+	// The WAF is reachable as long as one of the backends answers
+	status.Backends = a.backends.status()
+	probeResults := a.probeBackends()
+	for i := range status.Backends {
+		if err := probeResults[i]; err != nil {
+			status.Backends[i].Error = err.Error()
+			status.Error = err.Error()
+		} else {
+			status.Backends[i].Reachable = true
+			status.WafReachable = true
+		}
+	}
+	if status.WafReachable {
+		status.Error = ""
+	}
+	if !a.healthCheckDetails {
+		status.Error = ""
+		status.Backends = nil
+	}
+
+	statusCode := http.StatusOK
+	if !status.WafReachable || status.BackoffRemainingSecs > 0 {
+		status.Status = "unhealthy"
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(statusCode)
+	json.NewEncoder(rw).Encode(status)
+}
+
+// Health check probe results are reused for this long, so that frequent callers do not fan out to the WAF
+const healthProbeCacheDuration = time.Second
+
+// probeBackends probes every backend in parallel and returns the results in backend order
+func (a *Modsecurity) probeBackends() []error {
+	a.healthProbeMutex.Lock()
+	defer a.healthProbeMutex.Unlock()
+
+	if a.healthProbeResults != nil && time.Since(a.healthProbeTime) < healthProbeCacheDuration {
+		return a.healthProbeResults
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.healthCheckTimeout)
+	defer cancel()
+
+	statuses := a.backends.status()
+	results := make([]error, len(statuses))
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = a.probeWaf(ctx, statuses[i].Url)
+		}(i)
+	}
+	wg.Wait()
+
+	a.healthProbeResults = results
+	a.healthProbeTime = time.Now()
+	return results
+}
+
+// serveQuarantine lists the quarantined requests, most recent first, to callers presenting quarantineToken
+func (a *Modsecurity) serveQuarantine(rw http.ResponseWriter, req *http.Request) {
+	a.stats.flush()
+
+	expected := "Bearer " + a.quarantineToken
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(expected)) != 1 {
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="quarantine"`)
+		http.Error(rw, "", http.StatusUnauthorized)
+		return
+	}
+
+	entries := a.quarantine.snapshot()
+	if entries == nil {
+		entries = []quarantineEntry{}
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(struct {
+		Entries []quarantineEntry `json:"entries"`
+	}{entries})
+}
+
+// probeWaf checks that the WAF at wafUrl answers HTTP requests. Any response, whatever its status code, counts as reachable.
+func (a *Modsecurity) probeWaf(ctx context.Context, wafUrl string) error {
+	probeReq, err := http.NewRequestWithContext(ctx, http.MethodHead, wafUrl, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.httpClient.Do(probeReq)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
+
+// handleBodyTooLarge rejects a request whose body exceeds maxBodySizeBytes with 413, or forwards it
+// uninspected when maxBodySizeExceededAction is "skip". consumed holds the part of the body already read.
+func (a *Modsecurity) handleBodyTooLarge(rw http.ResponseWriter, req *http.Request, consumed []byte) {
+	if a.skipInspectionWhenBodyTooLarge {
+		if a.modSecurityStatusRequestHeader != "" {
+			req.Header.Set(a.modSecurityStatusRequestHeader, "skipped")
+		}
+		if len(consumed) > 0 {
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(consumed), req.Body), req.Body}
+		}
+		a.stats.recordBypassed("bodysize")
+		a.next.ServeHTTP(rw, req)
+		return
+	}
+
+	// Mark the request as blocked by the middleware itself (for access-log correlation)
+	if a.modSecurityStatusRequestHeader != "" {
+		req.Header.Set(a.modSecurityStatusRequestHeader, "blocked")
+	}
+	http.Error(rw, "Request body too large", http.StatusRequestEntityTooLarge) // 413
+	a.stats.recordRejected(http.StatusRequestEntityTooLarge)
+}
+
+// newProxyRequest prepares the request sent to the WAF at target, mirroring the incoming request.
+// The request target is copied byte for byte from req.RequestURI (encoded characters, semicolons,
+// duplicate slashes...) so that the WAF evaluates exactly what the upstream will receive.
+func newProxyRequest(ctx context.Context, target *url.URL, req *http.Request, body []byte) (*http.Request, error) {
+	// Create request body reader (nil for methods that ignore body)
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	proxyReq, err := http.NewRequestWithContext(ctx, req.Method, target.String(), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+
+	requestURI := req.RequestURI
+	if !strings.HasPrefix(requestURI, "/") {
+		// Requests built in-process have no RequestURI, and absolute-form or "*" targets have no raw path to keep
+		requestURI = req.URL.RequestURI()
+	}
+	rawPath, rawQuery, hasQuery := strings.Cut(requestURI, "?")
+
+	// Opaque is written as is on the request line, bypassing the re-encoding of URL.Path.
+	// An Opaque starting with "//" is sent in absolute form though, which the WAF would evaluate
+	// as a different REQUEST_URI. Those paths go through URL.RawPath instead, which Go writes back
+	// unchanged only when it is a valid encoding: anything else is refused rather than altered.
+	rawPath = strings.TrimSuffix(target.EscapedPath(), "/") + rawPath
+	if strings.HasPrefix(rawPath, "//") {
+		path, err := url.PathUnescape(rawPath)
+		if err != nil {
+			return nil, err
+		}
+		proxyReq.URL.Path = path
+		proxyReq.URL.RawPath = rawPath
+		if proxyReq.URL.EscapedPath() != rawPath {
+			return nil, fmt.Errorf("request path %q cannot be forwarded unchanged", rawPath)
+		}
+	} else {
+		proxyReq.URL.Opaque = rawPath
+	}
+	proxyReq.URL.RawQuery = rawQuery
+	proxyReq.URL.ForceQuery = hasQuery && rawQuery == ""
+
+	// We may want to filter some headers, otherwise we could just use a shallow copy
+	proxyReq.Header = make(http.Header, len(req.Header))
+	for h, val := range req.Header {
+		proxyReq.Header[h] = val
+	}
+	return proxyReq, nil
+}
+
+// applyUrlPolicy validates and normalizes the incoming request target according to the configuration.
+// Normalization rewrites the request itself so that the WAF and the upstream see the same URL.
+// It returns false when the request must be rejected.
+func (a *Modsecurity) applyUrlPolicy(req *http.Request) bool {
+	if !strings.HasPrefix(req.RequestURI, "/") {
+		return true
+	}
+
+	if a.rejectMalformedUrlEncoding && !hasValidPercentEncoding(req.RequestURI) {
+		return false
+	}
+
+	if a.normalizeUrlPath {
+		rawPath, rawQuery, hasQuery := strings.Cut(req.RequestURI, "?")
+		normalized := normalizePath(rawPath)
+		if normalized != rawPath {
+			if unescaped, err := url.PathUnescape(normalized); err == nil {
+				req.URL.Path = unescaped
+				req.URL.RawPath = normalized
+			}
+			req.RequestURI = normalized
+			if hasQuery {
+				req.RequestURI += "?" + rawQuery
+			}
+		}
+	}
+	return true
+}
+
 func isWebsocket(req *http.Request) bool {
 	for _, header := range req.Header["Upgrade"] {
 		if header == "websocket" {
@@ -3,12 +3,17 @@ package traefik_modsecurity
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -521,3 +526,648 @@ func TestModsecurity_BodySizeLimit_20MB_LargeBodies(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateTlsConfig(t *testing.T) {
+	tests := []struct {
+		name             string
+		config           *Config
+		expectError      bool
+		expectMinVersion uint16
+		expectMaxVersion uint16
+		expectCiphers    []uint16
+		expectNextProtos []string
+		expectServerName string
+	}{
+		{
+			name:             "Defaults to TLS 1.2 minimum",
+			config:           &Config{},
+			expectMinVersion: tls.VersionTLS12,
+		},
+		{
+			name:             "TLS 1.3 only",
+			config:           &Config{TlsMinVersion: "1.3", TlsMaxVersion: "1.3"},
+			expectMinVersion: tls.VersionTLS13,
+			expectMaxVersion: tls.VersionTLS13,
+		},
+		{
+			name: "Restricted cipher suites and ALPN",
+			config: &Config{
+				TlsCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+				TlsNextProtos:   []string{"http/1.1"},
+			},
+			expectMinVersion: tls.VersionTLS12,
+			expectCiphers:    []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+			expectNextProtos: []string{"http/1.1"},
+		},
+		{
+			name:             "Overrides server name",
+			config:           &Config{TlsServerName: "waf.internal.svc"},
+			expectMinVersion: tls.VersionTLS12,
+			expectServerName: "waf.internal.svc",
+		},
+		{
+			name:        "Rejects unknown version",
+			config:      &Config{TlsMinVersion: "1.4"},
+			expectError: true,
+		},
+		{
+			name:        "Rejects max version lower than min version",
+			config:      &Config{TlsMinVersion: "1.3", TlsMaxVersion: "1.2"},
+			expectError: true,
+		},
+		{
+			name:        "Rejects TLS 1.3 cipher suite",
+			config:      &Config{TlsCipherSuites: []string{"TLS_AES_256_GCM_SHA384"}},
+			expectError: true,
+		},
+		{
+			name:        "Rejects cipher suites when TLS 1.3 is the minimum",
+			config:      &Config{TlsMinVersion: "1.3", TlsCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}},
+			expectError: true,
+		},
+		{
+			name:        "Rejects insecure cipher suite",
+			config:      &Config{TlsCipherSuites: []string{"TLS_RSA_WITH_3DES_EDE_CBC_SHA"}},
+			expectError: true,
+		},
+		{
+			name:        "Rejects unknown cipher suite",
+			config:      &Config{TlsCipherSuites: []string{"TLS_NOT_A_SUITE"}},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := createTlsConfig(tt.config)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectMinVersion, tlsConfig.MinVersion)
+			assert.Equal(t, tt.expectMaxVersion, tlsConfig.MaxVersion)
+			assert.Equal(t, tt.expectCiphers, tlsConfig.CipherSuites)
+			assert.Equal(t, tt.expectNextProtos, tlsConfig.NextProtos)
+			assert.Equal(t, tt.expectServerName, tlsConfig.ServerName)
+		})
+	}
+}
+
+func TestModsecurity_TlsServerName(t *testing.T) {
+	// The httptest certificate is issued for 127.0.0.1, ::1 and example.com, but not for "localhost"
+	tests := []struct {
+		name               string
+		dialHost           string
+		tlsServerName      string
+		expectStatus       int
+		expectedServerName string
+	}{
+		{
+			name:               "Presents the configured name as SNI",
+			dialHost:           "127.0.0.1",
+			tlsServerName:      "example.com",
+			expectStatus:       http.StatusOK,
+			expectedServerName: "example.com",
+		},
+		{
+			name:         "Rejects a certificate that does not cover the dialed name",
+			dialHost:     "localhost",
+			expectStatus: http.StatusBadGateway,
+		},
+		{
+			name:               "Verifies the certificate against the configured name instead of the dialed one",
+			dialHost:           "localhost",
+			tlsServerName:      "example.com",
+			expectStatus:       http.StatusOK,
+			expectedServerName: "example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var receivedServerName string
+			modsecurityMockServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				receivedServerName = r.TLS.ServerName
+				w.WriteHeader(200)
+			}))
+			modsecurityMockServer.StartTLS()
+			defer modsecurityMockServer.Close()
+
+			httpServiceHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(200)
+			})
+
+			config := &Config{
+				TimeoutMillis:  2000,
+				ModSecurityUrl: "https://" + net.JoinHostPort(tt.dialHost, strconv.Itoa(modsecurityMockServer.Listener.Addr().(*net.TCPAddr).Port)),
+				TlsServerName:  tt.tlsServerName,
+			}
+
+			middleware, err := New(context.Background(), httpServiceHandler, config, "modsecurity-middleware")
+			if err != nil {
+				t.Fatalf("Failed to create middleware: %v", err)
+			}
+
+			// Trust the test server certificate
+			transport := middleware.(*Modsecurity).httpClient.Transport.(*http.Transport)
+			transport.TLSClientConfig.RootCAs = modsecurityMockServer.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			rw := httptest.NewRecorder()
+			middleware.ServeHTTP(rw, req)
+
+			assert.Equal(t, tt.expectStatus, rw.Result().StatusCode)
+			assert.Equal(t, tt.expectedServerName, receivedServerName)
+		})
+	}
+}
+
+func TestModsecurity_HealthCheck(t *testing.T) {
+	tests := []struct {
+		name                 string
+		wafDown              bool
+		backoff              bool
+		details              bool
+		expectStatus         int
+		expectHealthStatus   string
+		expectReachable      bool
+		expectBackoffPending bool
+	}{
+		{
+			name:               "Healthy when WAF is reachable",
+			expectStatus:       http.StatusOK,
+			expectHealthStatus: "healthy",
+			expectReachable:    true,
+		},
+		{
+			name:               "Unhealthy when WAF is unreachable",
+			wafDown:            true,
+			expectStatus:       http.StatusServiceUnavailable,
+			expectHealthStatus: "unhealthy",
+			expectReachable:    false,
+		},
+		{
+			name:                 "Unhealthy while backing off",
+			backoff:              true,
+			expectStatus:         http.StatusServiceUnavailable,
+			expectHealthStatus:   "unhealthy",
+			expectReachable:      true,
+			expectBackoffPending: true,
+		},
+		{
+			name:               "Reports backend details when enabled",
+			wafDown:            true,
+			details:            true,
+			expectStatus:       http.StatusServiceUnavailable,
+			expectHealthStatus: "unhealthy",
+			expectReachable:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modsecurityMockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(200)
+			}))
+			defer modsecurityMockServer.Close()
+
+			backendCalled := false
+			httpServiceHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				backendCalled = true
+				w.WriteHeader(200)
+			})
+
+			config := &Config{
+				TimeoutMillis:                 2000,
+				ModSecurityUrl:                modsecurityMockServer.URL,
+				UnhealthyWafBackOffPeriodSecs: 30,
+				HealthCheckPath:               "/.well-known/waf-health",
+				HealthCheckDetails:            tt.details,
+			}
+
+			middleware, err := New(context.Background(), httpServiceHandler, config, "modsecurity-middleware")
+			if err != nil {
+				t.Fatalf("Failed to create middleware: %v", err)
+			}
+
+			if tt.wafDown {
+				modsecurityMockServer.Close()
+			}
+			if tt.backoff {
+				modsec := middleware.(*Modsecurity)
+				modsec.unhealthyWaf = true
+				modsec.unhealthyWafUntil = time.Now().Add(30 * time.Second)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/.well-known/waf-health", nil)
+			rw := httptest.NewRecorder()
+			middleware.ServeHTTP(rw, req)
+			resp := rw.Result()
+
+			var status healthStatus
+			if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+				t.Fatalf("Failed to decode health status: %v", err)
+			}
+
+			assert.Equal(t, tt.expectStatus, resp.StatusCode)
+			assert.Equal(t, tt.expectHealthStatus, status.Status)
+			assert.Equal(t, tt.expectReachable, status.WafReachable)
+			if tt.expectBackoffPending {
+				assert.Greater(t, status.BackoffRemainingSecs, int64(0))
+			} else {
+				assert.Equal(t, int64(0), status.BackoffRemainingSecs)
+			}
+			if tt.details {
+				assert.Len(t, status.Backends, 1)
+				assert.Equal(t, modsecurityMockServer.URL, status.Backends[0].Url)
+				assert.NotEmpty(t, status.Backends[0].Error)
+				assert.NotEmpty(t, status.Error)
+			} else {
+				assert.Empty(t, status.Backends, "Backend URLs should not be public by default")
+				assert.Empty(t, status.Error, "Probe errors should not be public by default")
+			}
+			assert.False(t, backendCalled, "Health check should not reach the backend")
+		})
+	}
+}
+
+func TestCreateDialer(t *testing.T) {
+	tests := []struct {
+		name            string
+		config          *Config
+		expectTimeout   time.Duration
+		expectKeepAlive time.Duration
+	}{
+		{
+			name:            "Keeps original defaults when not configured",
+			config:          &Config{},
+			expectTimeout:   30 * time.Second,
+			expectKeepAlive: 30 * time.Second,
+		},
+		{
+			name:            "Applies configured timeout and keep-alive",
+			config:          &Config{DialTimeoutMillis: 500, DialKeepAliveMillis: 10000},
+			expectTimeout:   500 * time.Millisecond,
+			expectKeepAlive: 10 * time.Second,
+		},
+		{
+			name:            "Negative keep-alive disables it",
+			config:          &Config{DialKeepAliveMillis: -1},
+			expectTimeout:   30 * time.Second,
+			expectKeepAlive: -time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := createDialer(tt.config)
+			assert.Equal(t, tt.expectTimeout, dialer.Timeout)
+			assert.Equal(t, tt.expectKeepAlive, dialer.KeepAlive)
+		})
+	}
+}
+
+func TestModsecurity_TransportConfiguration(t *testing.T) {
+	tests := []struct {
+		name                  string
+		config                *Config
+		expectMaxIdleConns    int
+		expectIdleConnTimeout time.Duration
+	}{
+		{
+			name:                  "Keeps original defaults when not configured",
+			config:                &Config{ModSecurityUrl: "http://waf:8080"},
+			expectMaxIdleConns:    100,
+			expectIdleConnTimeout: 90 * time.Second,
+		},
+		{
+			name: "Applies configured idle pool settings",
+			config: &Config{
+				ModSecurityUrl:        "http://waf:8080",
+				MaxIdleConns:          20,
+				IdleConnTimeoutMillis: 5000,
+				DialTimeoutMillis:     500,
+				DialKeepAliveMillis:   -1,
+			},
+			expectMaxIdleConns:    20,
+			expectIdleConnTimeout: 5 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			middleware, err := New(context.Background(), http.NotFoundHandler(), tt.config, "modsecurity-middleware")
+			if err != nil {
+				t.Fatalf("Failed to create middleware: %v", err)
+			}
+
+			transport := middleware.(*Modsecurity).httpClient.Transport.(*http.Transport)
+			assert.Equal(t, tt.expectMaxIdleConns, transport.MaxIdleConns)
+			assert.Equal(t, tt.expectIdleConnTimeout, transport.IdleConnTimeout)
+		})
+	}
+}
+
+// countingReader records how many bytes were read from the request body
+type countingReader struct {
+	reader io.Reader
+	read   int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+func (r *countingReader) Close() error {
+	return nil
+}
+
+func TestModsecurity_BodySizeLimit_EarlyRejection(t *testing.T) {
+	const maxBodySizeBytes = int64(1024)
+
+	tests := []struct {
+		name                      string
+		bodySize                  int
+		contentLength             int64 // -1 for chunked requests with no declared length
+		maxBodySizeExceededAction string
+		expectStatus              int
+		expectWafCalled           bool
+		expectBackendBodyLen      int
+		expectMaxBytesRead        int64
+	}{
+		{
+			name:                 "Rejects declared length over the limit without reading the body",
+			bodySize:             4096,
+			contentLength:        4096,
+			expectStatus:         http.StatusRequestEntityTooLarge,
+			expectBackendBodyLen: -1,
+			expectMaxBytesRead:   0,
+		},
+		{
+			name:                 "Rejects chunked body over the limit after reading one byte past it",
+			bodySize:             4096,
+			contentLength:        -1,
+			expectStatus:         http.StatusRequestEntityTooLarge,
+			expectBackendBodyLen: -1,
+			expectMaxBytesRead:   maxBodySizeBytes + 1,
+		},
+		{
+			name:                 "Inspects chunked body within the limit",
+			bodySize:             512,
+			contentLength:        -1,
+			expectStatus:         http.StatusOK,
+			expectWafCalled:      true,
+			expectBackendBodyLen: 512,
+			expectMaxBytesRead:   512,
+		},
+		{
+			name:                      "Skips inspection for declared length over the limit",
+			bodySize:                  4096,
+			contentLength:             4096,
+			maxBodySizeExceededAction: "skip",
+			expectStatus:              http.StatusOK,
+			expectBackendBodyLen:      4096,
+			expectMaxBytesRead:        4096,
+		},
+		{
+			name:                      "Skips inspection for chunked body over the limit and forwards it whole",
+			bodySize:                  4096,
+			contentLength:             -1,
+			maxBodySizeExceededAction: "skip",
+			expectStatus:              http.StatusOK,
+			expectBackendBodyLen:      4096,
+			expectMaxBytesRead:        4096,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wafCalled := false
+			modsecurityMockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				wafCalled = true
+				w.WriteHeader(200)
+			}))
+			defer modsecurityMockServer.Close()
+
+			backendBodyLen := -1
+			httpServiceHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				backendBodyLen = len(body)
+				w.WriteHeader(200)
+			})
+
+			body := &countingReader{reader: bytes.NewReader(bytes.Repeat([]byte("a"), tt.bodySize))}
+			req := httptest.NewRequest(http.MethodPost, "/test", nil)
+			req.Body = body
+			req.ContentLength = tt.contentLength
+
+			config := &Config{
+				TimeoutMillis:             2000,
+				ModSecurityUrl:            modsecurityMockServer.URL,
+				MaxBodySizeBytes:          maxBodySizeBytes,
+				MaxBodySizeExceededAction: tt.maxBodySizeExceededAction,
+			}
+
+			middleware, err := New(context.Background(), httpServiceHandler, config, "modsecurity-middleware")
+			if err != nil {
+				t.Fatalf("Failed to create middleware: %v", err)
+			}
+
+			rw := httptest.NewRecorder()
+			middleware.ServeHTTP(rw, req)
+
+			assert.Equal(t, tt.expectStatus, rw.Result().StatusCode)
+			assert.Equal(t, tt.expectWafCalled, wafCalled)
+			assert.Equal(t, tt.expectBackendBodyLen, backendBodyLen)
+			assert.Equal(t, tt.expectMaxBytesRead, body.read)
+		})
+	}
+}
+
+func TestModsecurity_UrlForwarding(t *testing.T) {
+	tests := []struct {
+		name                       string
+		requestURI                 string
+		modSecurityPathPrefix      string
+		rejectMalformedUrlEncoding bool
+		normalizeUrlPath           bool
+		expectStatus               int
+		expectWafRequestURI        string
+		expectBackendRequestURI    string
+	}{
+		{
+			name:                    "Forwards encoded characters, semicolons and duplicate slashes untouched",
+			requestURI:              "/a%2fb;jsessionid=1//c/%7e?q=a%20b;c&q=%2F",
+			expectStatus:            http.StatusOK,
+			expectWafRequestURI:     "/a%2fb;jsessionid=1//c/%7e?q=a%20b;c&q=%2F",
+			expectBackendRequestURI: "/a%2fb;jsessionid=1//c/%7e?q=a%20b;c&q=%2F",
+		},
+		{
+			name:                    "Does not re-encode characters the URL parser would escape",
+			requestURI:              "/a\"b/{c}",
+			expectStatus:            http.StatusOK,
+			expectWafRequestURI:     "/a\"b/{c}",
+			expectBackendRequestURI: "/a\"b/{c}",
+		},
+		{
+			name:                    "Does not drop what looks like a fragment",
+			requestURI:              "/a#/../admin",
+			expectStatus:            http.StatusOK,
+			expectWafRequestURI:     "/a#/../admin",
+			expectBackendRequestURI: "/a#/../admin",
+		},
+		{
+			name:                    "Keeps leading double slashes in origin form",
+			requestURI:              "//evil/x%2f;a=b//c?q=1",
+			expectStatus:            http.StatusOK,
+			expectWafRequestURI:     "//evil/x%2f;a=b//c?q=1",
+			expectBackendRequestURI: "//evil/x%2f;a=b//c?q=1",
+		},
+		{
+			name:         "Refuses leading double slashes that cannot be forwarded unchanged",
+			requestURI:   "//evil/a\"b",
+			expectStatus: http.StatusBadGateway,
+		},
+		{
+			name:                    "Keeps the modSecurityUrl path prefix",
+			requestURI:              "/a//b",
+			modSecurityPathPrefix:   "/waf",
+			expectStatus:            http.StatusOK,
+			expectWafRequestURI:     "/waf/a//b",
+			expectBackendRequestURI: "/a//b",
+		},
+		{
+			name:                    "Forwards malformed encodings when not rejecting",
+			requestURI:              "/a?b=%zz",
+			expectStatus:            http.StatusOK,
+			expectWafRequestURI:     "/a?b=%zz",
+			expectBackendRequestURI: "/a?b=%zz",
+		},
+		{
+			name:                       "Rejects malformed encodings",
+			requestURI:                 "/a?b=%zz",
+			rejectMalformedUrlEncoding: true,
+			expectStatus:               http.StatusBadRequest,
+		},
+		{
+			name:                    "Normalizes the path for both the WAF and the upstream",
+			requestURI:              "/a//./b/../%7ec%2f?q=%7e",
+			normalizeUrlPath:        true,
+			expectStatus:            http.StatusOK,
+			expectWafRequestURI:     "/a/~c%2F?q=%7e",
+			expectBackendRequestURI: "/a/~c%2F?q=%7e",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wafRequestURI := ""
+			modsecurityMockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				wafRequestURI = r.RequestURI
+				w.WriteHeader(200)
+			}))
+			defer modsecurityMockServer.Close()
+
+			backendRequestURI := ""
+			httpServiceHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				backendRequestURI = r.RequestURI
+				w.WriteHeader(200)
+			})
+
+			config := &Config{
+				TimeoutMillis:              2000,
+				ModSecurityUrl:             modsecurityMockServer.URL + tt.modSecurityPathPrefix,
+				RejectMalformedUrlEncoding: tt.rejectMalformedUrlEncoding,
+				NormalizeUrlPath:           tt.normalizeUrlPath,
+			}
+
+			middleware, err := New(context.Background(), httpServiceHandler, config, "modsecurity-middleware")
+			if err != nil {
+				t.Fatalf("Failed to create middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RequestURI = tt.requestURI
+
+			rw := httptest.NewRecorder()
+			middleware.ServeHTTP(rw, req)
+
+			assert.Equal(t, tt.expectStatus, rw.Result().StatusCode)
+			assert.Equal(t, tt.expectWafRequestURI, wafRequestURI)
+			assert.Equal(t, tt.expectBackendRequestURI, backendRequestURI)
+		})
+	}
+}
+
+func TestModsecurity_LogsBackendUrlOnFailure(t *testing.T) {
+	modsecurityMockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	modsecurityMockServer.Close()
+
+	config := &Config{
+		TimeoutMillis:  2000,
+		ModSecurityUrl: modsecurityMockServer.URL,
+	}
+
+	middleware, err := New(context.Background(), http.NotFoundHandler(), config, "modsecurity-middleware")
+	if err != nil {
+		t.Fatalf("Failed to create middleware: %v", err)
+	}
+	var output bytes.Buffer
+	middleware.(*Modsecurity).logger = log.New(&output, "", 0)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RequestURI = "/attack"
+	rw := httptest.NewRecorder()
+	middleware.ServeHTTP(rw, req)
+
+	// The request target is sent raw, the error itself would only name "http:/attack"
+	assert.Equal(t, http.StatusBadGateway, rw.Result().StatusCode)
+	assert.Contains(t, output.String(), "fail to send HTTP request to modsec "+modsecurityMockServer.URL+": ")
+	assert.NotContains(t, output.String(), "http:/attack")
+}
+
+func TestModsecurity_HealthCheck_ProbesInParallelWithOwnTimeout(t *testing.T) {
+	var probes int32
+	slowWaf := func() *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&probes, 1)
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * time.Second):
+			}
+		}))
+	}
+	wafA, wafB := slowWaf(), slowWaf()
+	defer wafA.Close()
+	defer wafB.Close()
+
+	config := &Config{
+		TimeoutMillis:            2000,
+		ModSecurityUrl:           wafA.URL,
+		ModSecurityUrls:          []string{wafB.URL},
+		HealthCheckPath:          "/.well-known/waf-health",
+		HealthCheckTimeoutMillis: 200,
+	}
+
+	middleware, err := New(context.Background(), http.NotFoundHandler(), config, "modsecurity-middleware")
+	if err != nil {
+		t.Fatalf("Failed to create middleware: %v", err)
+	}
+
+	start := time.Now()
+	rw := httptest.NewRecorder()
+	middleware.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/.well-known/waf-health", nil))
+	elapsed := time.Since(start)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Result().StatusCode)
+	assert.Less(t, elapsed, time.Second, "Both backends should be probed at once with the health check timeout")
+
+	// A second call right away reuses the results instead of probing again
+	rw = httptest.NewRecorder()
+	middleware.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/.well-known/waf-health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Result().StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&probes))
+}
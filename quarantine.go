@@ -0,0 +1,151 @@
+package traefik_modsecurity
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const redactedValue = "[REDACTED]"
+
+// Bytes read past quarantineMaxBodyBytes so that redaction patterns can match across the limit
+const quarantineRedactMargin = 1024
+
+// quarantineEntry is a sample of a request blocked by the WAF
+type quarantineEntry struct {
+	Time          time.Time           `json:"time"`
+	Status        int                 `json:"status"`
+	Method        string              `json:"method"`
+	Host          string              `json:"host"`
+	RequestURI    string              `json:"requestUri"`
+	RemoteAddr    string              `json:"remoteAddr"`
+	Headers       map[string][]string `json:"headers"`
+	Body          string              `json:"body"`
+	BodySize      int                 `json:"bodySize"`
+	BodyTruncated bool                `json:"bodyTruncated"`
+}
+
+// quarantineStore keeps the last blocked requests in a fixed size ring buffer so that false
+// positives can be reproduced without asking for the payload again.
+// All methods are no-ops on a nil receiver, which is how the store is disabled.
+type quarantineStore struct {
+	mu             sync.Mutex
+	entries        []quarantineEntry
+	next           int // Position of the next entry to overwrite
+	full           bool
+	maxBodyBytes   int
+	redactHeaders  map[string]bool  // Canonical header names whose values are redacted
+	redactPatterns []*regexp.Regexp // Matches are redacted from the request URI, header values and body
+}
+
+func newQuarantineStore(size int, maxBodyBytes int, redactHeaders []string, redactPatterns []*regexp.Regexp) *quarantineStore {
+	headers := make(map[string]bool, len(redactHeaders))
+	for _, header := range redactHeaders {
+		headers[http.CanonicalHeaderKey(header)] = true
+	}
+	return &quarantineStore{
+		entries:        make([]quarantineEntry, size),
+		maxBodyBytes:   maxBodyBytes,
+		redactHeaders:  headers,
+		redactPatterns: redactPatterns,
+	}
+}
+
+// record stores a redacted copy of the request. body may be a pooled buffer, it is not retained.
+func (q *quarantineStore) record(req *http.Request, body []byte, status int) {
+	if q == nil {
+		return
+	}
+
+	entry := quarantineEntry{
+		Time:       time.Now(),
+		Status:     status,
+		Method:     req.Method,
+		Host:       req.Host,
+		RequestURI: q.redact(req.RequestURI),
+		RemoteAddr: req.RemoteAddr,
+		Headers:    make(map[string][]string, len(req.Header)),
+		BodySize:   len(body),
+	}
+	for name, values := range req.Header {
+		if q.redactHeaders[name] {
+			entry.Headers[name] = []string{redactedValue}
+			continue
+		}
+		// Header values such as Referer can carry the same secrets as the request URI
+		redacted := make([]string, len(values))
+		for i, value := range values {
+			redacted[i] = q.redact(value)
+		}
+		entry.Headers[name] = redacted
+	}
+	// Only the beginning of the body is copied and redacted. With redaction patterns a margin is
+	// kept past the limit, so that a secret cut at the limit still matches before being truncated.
+	limit := q.maxBodyBytes
+	if len(q.redactPatterns) > 0 {
+		limit += quarantineRedactMargin
+	}
+	sample := body
+	if len(sample) > limit {
+		sample = sample[:limit]
+	}
+	entry.Body = q.redact(string(sample))
+	entry.BodyTruncated = len(sample) < len(body)
+	if len(entry.Body) > q.maxBodyBytes {
+		entry.Body = entry.Body[:q.maxBodyBytes]
+		entry.BodyTruncated = true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entries[q.next] = entry
+	q.next = (q.next + 1) % len(q.entries)
+	if q.next == 0 {
+		q.full = true
+	}
+}
+
+func (q *quarantineStore) redact(value string) string {
+	if len(q.redactPatterns) == 0 {
+		return value
+	}
+	for _, pattern := range q.redactPatterns {
+		value = pattern.ReplaceAllString(value, redactedValue)
+	}
+	return value
+}
+
+// snapshot returns the stored entries, most recent first
+func (q *quarantineStore) snapshot() []quarantineEntry {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	count := q.next
+	if q.full {
+		count = len(q.entries)
+	}
+	entries := make([]quarantineEntry, 0, count)
+	for i := 1; i <= count; i++ {
+		entries = append(entries, q.entries[(q.next-i+len(q.entries))%len(q.entries)])
+	}
+	return entries
+}
+
+// compileRedactPatterns compiles the configured redaction regular expressions
+func compileRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quarantineRedactPatterns entry %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
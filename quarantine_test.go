@@ -0,0 +1,150 @@
+package traefik_modsecurity
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantineStore_KeepsLastEntries(t *testing.T) {
+	store := newQuarantineStore(2, 1024, nil, nil)
+
+	for i := 1; i <= 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/"+strconv.Itoa(i), nil)
+		store.record(req, nil, 403)
+	}
+
+	entries := store.snapshot()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "/3", entries[0].RequestURI)
+	assert.Equal(t, "/2", entries[1].RequestURI)
+}
+
+func TestQuarantineStore_RedactsAndTruncates(t *testing.T) {
+	store := newQuarantineStore(10, 16, []string{"authorization", "Cookie"}, []*regexp.Regexp{regexp.MustCompile(`password=[^&]*`)})
+
+	req := httptest.NewRequest(http.MethodPost, "/login?password=secret&user=bob", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("Cookie", "session=abc")
+	req.Header.Set("User-Agent", "test")
+	req.Header.Set("Referer", "https://site/login?password=secret")
+	body := []byte("password=hunter2&remember=true")
+	store.record(req, body, 403)
+
+	// The body slice may be a pooled buffer reused after the request
+	copy(body, strings.Repeat("x", len(body)))
+
+	entry := store.snapshot()[0]
+	assert.Equal(t, "/login?[REDACTED]&user=bob", entry.RequestURI)
+	assert.Equal(t, []string{"[REDACTED]"}, entry.Headers["Authorization"])
+	assert.Equal(t, []string{"[REDACTED]"}, entry.Headers["Cookie"])
+	assert.Equal(t, []string{"test"}, entry.Headers["User-Agent"])
+	assert.Equal(t, []string{"https://site/login?[REDACTED]"}, entry.Headers["Referer"])
+	assert.Equal(t, "[REDACTED]&remem", entry.Body)
+	assert.Equal(t, 30, entry.BodySize)
+	assert.True(t, entry.BodyTruncated)
+}
+
+func TestModsecurity_QuarantineEndpoint(t *testing.T) {
+	modsecurityMockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.RawQuery, "etc") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer modsecurityMockServer.Close()
+
+	config := &Config{
+		TimeoutMillis:   2000,
+		ModSecurityUrl:  modsecurityMockServer.URL,
+		QuarantineSize:  10,
+		QuarantinePath:  "/.well-known/waf-quarantine",
+		QuarantineToken: "operator-secret",
+	}
+
+	middleware, err := New(context.Background(), http.NotFoundHandler(), config, "modsecurity-middleware")
+	if err != nil {
+		t.Fatalf("Failed to create middleware: %v", err)
+	}
+
+	for _, target := range []string{"/allowed", "/blocked?test=../etc"} {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader("payload"))
+		req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+		middleware.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for _, authorization := range []string{"", "Bearer wrong-secret"} {
+		req := httptest.NewRequest(http.MethodGet, "/.well-known/waf-quarantine", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rw := httptest.NewRecorder()
+		middleware.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusUnauthorized, rw.Result().StatusCode, "Quarantine should not be readable without the token")
+		assert.NotContains(t, rw.Body.String(), "payload")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/waf-quarantine", nil)
+	req.Header.Set("Authorization", "Bearer operator-secret")
+	rw := httptest.NewRecorder()
+	middleware.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Result().StatusCode)
+
+	var result struct {
+		Entries []quarantineEntry `json:"entries"`
+	}
+	if err := json.NewDecoder(rw.Result().Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode quarantine: %v", err)
+	}
+
+	assert.Len(t, result.Entries, 1, "Only blocked requests should be quarantined")
+	assert.Equal(t, http.StatusForbidden, result.Entries[0].Status)
+	assert.Equal(t, "/blocked?test=../etc", result.Entries[0].RequestURI)
+	assert.Equal(t, "payload", result.Entries[0].Body)
+	assert.Equal(t, []string{"[REDACTED]"}, result.Entries[0].Headers["Authorization"], "Default redaction should apply")
+}
+
+func TestQuarantineStore_BoundsWorkOnLargeBodies(t *testing.T) {
+	// The secret straddles the limit: it is still redacted thanks to the margin
+	body := []byte(strings.Repeat("a", 10) + "password=hunter2" + strings.Repeat("b", 1024*1024))
+	store := newQuarantineStore(1, 16, nil, []*regexp.Regexp{regexp.MustCompile(`password=[^&b]*`)})
+	store.record(httptest.NewRequest(http.MethodPost, "/", nil), body, 403)
+
+	entry := store.snapshot()[0]
+	assert.Equal(t, "aaaaaaaaaa[REDA", entry.Body[:15])
+	assert.Len(t, entry.Body, 16)
+	assert.Equal(t, len(body), entry.BodySize)
+	assert.True(t, entry.BodyTruncated)
+
+	// Without patterns only the limit is kept
+	store = newQuarantineStore(1, 16, nil, nil)
+	store.record(httptest.NewRequest(http.MethodPost, "/", nil), body, 403)
+	entry = store.snapshot()[0]
+	assert.Equal(t, "aaaaaaaaaapasswo", entry.Body)
+	assert.True(t, entry.BodyTruncated)
+
+	// Bodies within the limit are not reported as truncated
+	store.record(httptest.NewRequest(http.MethodPost, "/", nil), []byte("short"), 403)
+	entry = store.snapshot()[0]
+	assert.Equal(t, "short", entry.Body)
+	assert.False(t, entry.BodyTruncated)
+}
+
+func TestModsecurity_QuarantinePathRequiresToken(t *testing.T) {
+	config := &Config{
+		ModSecurityUrl: "http://modsecurity",
+		QuarantineSize: 10,
+		QuarantinePath: "/.well-known/waf-quarantine",
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), config, "modsecurity-middleware")
+	assert.Error(t, err)
+}
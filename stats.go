@@ -0,0 +1,192 @@
+package traefik_modsecurity
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maximum number of latency samples kept per interval, extra samples are reservoir sampled
+const maxLatencySamples = 1024
+
+// requestStats accumulates request counters and logs a summary once per interval.
+// There is no background goroutine: the summary is emitted by the first request recorded or the
+// first health check after the interval has elapsed, so instances discarded on a Traefik
+// configuration reload leave nothing behind. Traefik does not cancel the context given to
+// plugins on reload, a ticker tied to it would leak.
+// All methods are no-ops on a nil receiver, which is how statistics are disabled.
+type requestStats struct {
+	mu             sync.Mutex
+	logger         *log.Logger
+	name           string
+	interval       time.Duration
+	intervalStart  time.Time
+	inspected      int64
+	allowed        int64
+	wafErrors      int64
+	blocked        map[int]int64    // Blocked requests by returned status code
+	bypassed       map[string]int64 // Requests not inspected by reason
+	latencies      []time.Duration  // Reservoir of WAF inspection latencies
+	latencySamples int64            // Number of latencies seen during the interval
+}
+
+func newRequestStats(logger *log.Logger, name string, interval time.Duration) *requestStats {
+	return &requestStats{
+		logger:        logger,
+		name:          name,
+		interval:      interval,
+		intervalStart: time.Now(),
+		blocked:       make(map[int]int64),
+		bypassed:      make(map[string]int64),
+	}
+}
+
+// recordAllowed records a request the WAF inspected and let through
+func (s *requestStats) recordAllowed(latency time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inspected++
+	s.allowed++
+	s.addLatency(latency)
+	s.flushIfDue()
+}
+
+// recordBlocked records a request the WAF inspected and rejected
+func (s *requestStats) recordBlocked(statusCode int, latency time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inspected++
+	s.blocked[statusCode]++
+	s.addLatency(latency)
+	s.flushIfDue()
+}
+
+// recordRejected records a request rejected by the middleware itself before reaching the WAF
+func (s *requestStats) recordRejected(statusCode int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocked[statusCode]++
+	s.flushIfDue()
+}
+
+// recordBypassed records a request forwarded without inspection
+func (s *requestStats) recordBypassed(reason string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bypassed[reason]++
+	s.flushIfDue()
+}
+
+// recordWafError records a failure to get a verdict from the WAF
+func (s *requestStats) recordWafError() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wafErrors++
+	s.flushIfDue()
+}
+
+// flush logs the pending interval if it has elapsed, for endpoints that record no request
+func (s *requestStats) flush() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushIfDue()
+}
+
+func (s *requestStats) addLatency(latency time.Duration) {
+	s.latencySamples++
+	if len(s.latencies) < maxLatencySamples {
+		s.latencies = append(s.latencies, latency)
+		return
+	}
+	if i := rand.Int63n(s.latencySamples); i < maxLatencySamples {
+		s.latencies[i] = latency
+	}
+}
+
+// flushIfDue logs and resets the counters once the interval has elapsed. Must be called with mu held.
+func (s *requestStats) flushIfDue() {
+	now := time.Now()
+	elapsed := now.Sub(s.intervalStart)
+	if elapsed < s.interval {
+		return
+	}
+
+	p50, p99 := latencyPercentiles(s.latencies)
+	s.logger.Printf("modsecurity stats %s: interval=%s inspected=%d allowed=%d blocked=%s bypassed=%s wafErrors=%d latencyP50=%s latencyP99=%s",
+		s.name, elapsed.Round(time.Second), s.inspected, s.allowed, formatBlocked(s.blocked), formatBypassed(s.bypassed), s.wafErrors, p50, p99)
+
+	s.intervalStart = now
+	s.inspected = 0
+	s.allowed = 0
+	s.wafErrors = 0
+	s.blocked = make(map[int]int64)
+	s.bypassed = make(map[string]int64)
+	s.latencies = s.latencies[:0]
+	s.latencySamples = 0
+}
+
+// latencyPercentiles returns the p50 and p99 of the samples using the nearest-rank method
+func latencyPercentiles(samples []time.Duration) (time.Duration, time.Duration) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := func(p float64) time.Duration {
+		return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+	}
+	return rank(0.50), rank(0.99)
+}
+
+func formatBlocked(blocked map[int]int64) string {
+	codes := make([]int, 0, len(blocked))
+	for code := range blocked {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	parts := make([]string, 0, len(codes))
+	var total int64
+	for _, code := range codes {
+		total += blocked[code]
+		parts = append(parts, fmt.Sprintf("%d:%d", code, blocked[code]))
+	}
+	return fmt.Sprintf("%d{%s}", total, strings.Join(parts, ","))
+}
+
+func formatBypassed(bypassed map[string]int64) string {
+	reasons := make([]string, 0, len(bypassed))
+	for reason := range bypassed {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	parts := make([]string, 0, len(reasons))
+	var total int64
+	for _, reason := range reasons {
+		total += bypassed[reason]
+		parts = append(parts, fmt.Sprintf("%s:%d", reason, bypassed[reason]))
+	}
+	return fmt.Sprintf("%d{%s}", total, strings.Join(parts, ","))
+}
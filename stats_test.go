@@ -0,0 +1,82 @@
+package traefik_modsecurity
+
+import (
+	"bytes"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestStats_LogsSummaryOncePerInterval(t *testing.T) {
+	var output bytes.Buffer
+	stats := newRequestStats(log.New(&output, "", 0), "waf", time.Minute)
+
+	stats.recordAllowed(10 * time.Millisecond)
+	stats.recordAllowed(20 * time.Millisecond)
+	stats.recordBlocked(403, 30*time.Millisecond)
+	stats.recordRejected(413)
+	stats.recordBypassed("websocket")
+	stats.recordWafError()
+	assert.Empty(t, output.String(), "Nothing should be logged before the interval elapses")
+
+	// Pretend the interval is over, the next recorded request emits the summary
+	stats.intervalStart = time.Now().Add(-time.Minute)
+	stats.recordBypassed("unhealthy")
+
+	line := output.String()
+	assert.Contains(t, line, "modsecurity stats waf:")
+	assert.Contains(t, line, "inspected=3 allowed=2")
+	assert.Contains(t, line, "blocked=2{403:1,413:1}")
+	assert.Contains(t, line, "bypassed=2{unhealthy:1,websocket:1}")
+	assert.Contains(t, line, "wafErrors=1")
+	assert.Contains(t, line, "latencyP50=20ms latencyP99=30ms")
+
+	// Counters are reset for the next interval
+	output.Reset()
+	stats.intervalStart = time.Now().Add(-time.Minute)
+	stats.recordAllowed(5 * time.Millisecond)
+	assert.Contains(t, output.String(), "inspected=1 allowed=1 blocked=0{} bypassed=0{} wafErrors=0")
+}
+
+func TestRequestStats_NilIsDisabled(t *testing.T) {
+	var stats *requestStats
+	assert.NotPanics(t, func() {
+		stats.recordAllowed(time.Millisecond)
+		stats.recordBlocked(403, time.Millisecond)
+		stats.recordRejected(413)
+		stats.recordBypassed("websocket")
+		stats.recordWafError()
+		stats.flush()
+	})
+}
+
+func TestRequestStats_FlushEmitsPendingInterval(t *testing.T) {
+	var output bytes.Buffer
+	stats := newRequestStats(log.New(&output, "", 0), "waf", time.Minute)
+
+	stats.recordBlocked(403, 10*time.Millisecond)
+	stats.flush()
+	assert.Empty(t, output.String(), "Nothing should be logged before the interval elapses")
+
+	// No request comes in after the interval, the flush alone emits it
+	stats.intervalStart = time.Now().Add(-time.Minute)
+	stats.flush()
+	assert.Contains(t, output.String(), "inspected=1 allowed=0 blocked=1{403:1}")
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	samples := make([]time.Duration, 0, 100)
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+
+	p50, p99 := latencyPercentiles(samples)
+	assert.Equal(t, 50*time.Millisecond, p50)
+	assert.Equal(t, 99*time.Millisecond, p99)
+
+	p50, p99 = latencyPercentiles(nil)
+	assert.Equal(t, time.Duration(0), p50)
+	assert.Equal(t, time.Duration(0), p99)
+}
@@ -0,0 +1,74 @@
+package traefik_modsecurity
+
+import (
+	"path"
+	"strings"
+)
+
+// hasValidPercentEncoding reports whether every '%' in s starts a two hex digit escape
+func hasValidPercentEncoding(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			continue
+		}
+		if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			return false
+		}
+		i += 2
+	}
+	return true
+}
+
+// normalizePath applies the RFC 3986 section 6.2.2 normalizations to an escaped path:
+// percent-encoded unreserved characters are decoded, the remaining escapes are uppercased,
+// duplicate slashes are collapsed and dot segments are removed. Malformed escapes are kept as is.
+func normalizePath(rawPath string) string {
+	if rawPath == "" || rawPath[0] != '/' {
+		return rawPath
+	}
+
+	var b strings.Builder
+	b.Grow(len(rawPath))
+	for i := 0; i < len(rawPath); i++ {
+		c := rawPath[i]
+		if c == '%' && i+2 < len(rawPath) && isHex(rawPath[i+1]) && isHex(rawPath[i+2]) {
+			decoded := unhex(rawPath[i+1])<<4 | unhex(rawPath[i+2])
+			if isUnreserved(decoded) {
+				b.WriteByte(decoded)
+			} else {
+				b.WriteByte('%')
+				b.WriteString(strings.ToUpper(rawPath[i+1 : i+3]))
+			}
+			i += 2
+			continue
+		}
+		b.WriteByte(c)
+	}
+	decoded := b.String()
+
+	// path.Clean collapses duplicate slashes and resolves dot segments but drops the trailing slash
+	cleaned := path.Clean(decoded)
+	if cleaned != "/" && (strings.HasSuffix(decoded, "/") || strings.HasSuffix(decoded, "/.") || strings.HasSuffix(decoded, "/..")) {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
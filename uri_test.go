@@ -0,0 +1,53 @@
+package traefik_modsecurity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasValidPercentEncoding(t *testing.T) {
+	tests := []struct {
+		uri    string
+		expect bool
+	}{
+		{"/a/b?c=d", true},
+		{"/a%2Fb%7e?c=%20", true},
+		{"/a%zzb", false},
+		{"/a%2", false},
+		{"/a?b=%", false},
+		{"/a%%41", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			assert.Equal(t, tt.expect, hasValidPercentEncoding(tt.uri))
+		})
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		rawPath string
+		expect  string
+	}{
+		{"/a/b", "/a/b"},
+		{"/a//b///c", "/a/b/c"},
+		{"/a/./b/../c/", "/a/c/"},
+		{"/a/b/..", "/a/"},
+		{"/../a", "/a"},
+		{"/%7euser/%61", "/~user/a"},
+		{"/a%2fb", "/a%2Fb"},
+		{"/%2e%2e/etc/passwd", "/etc/passwd"},
+		{"/a;b=c//d", "/a;b=c/d"},
+		{"/a%zz", "/a%zz"},
+		{"/", "/"},
+		{"*", "*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rawPath, func(t *testing.T) {
+			assert.Equal(t, tt.expect, normalizePath(tt.rawPath))
+		})
+	}
+}